@@ -0,0 +1,407 @@
+// Copyright 2012, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"golang.org/x/net/context"
+)
+
+// backupParams bundles together everything a backupEngine needs to run
+// a single backup.
+type backupParams struct {
+	mysqld      *Mysqld
+	storage     BackupStorage
+	concurrency int
+	// name is the backup's directory/prefix within storage; engines
+	// namespace their files underneath it.
+	name string
+}
+
+// backupEngine implements the mechanics of one way of taking and
+// restoring a backup. "xtrabackup" and "builtin" below are the two
+// engines Backup/Restore know how to dispatch to.
+type backupEngine interface {
+	executeBackup(ctx context.Context, p backupParams) (*BackupManifest, error)
+	executeRestore(ctx context.Context, mysqld *Mysqld, manifest *BackupManifest, storage BackupStorage) error
+}
+
+var backupEngines = map[string]backupEngine{
+	"xtrabackup": xtrabackupEngine{},
+	"builtin":    builtinEngine{},
+}
+
+// Backup is part of the MysqlDaemon interface
+func (mysqld *Mysqld) Backup(ctx context.Context, engine string, concurrency int) (*BackupManifest, error) {
+	if mysqld.backupStorage == nil {
+		return nil, fmt.Errorf("no BackupStorage configured; call SetBackupStorage first")
+	}
+	if concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be >= 1, got %v", concurrency)
+	}
+	eng, ok := backupEngines[engine]
+	if !ok {
+		return nil, fmt.Errorf("unknown backup engine %q, know: xtrabackup, builtin", engine)
+	}
+
+	pos, err := mysqld.MasterPosition()
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture GTID position before backup: %v", err)
+	}
+
+	manifest, err := eng.executeBackup(ctx, backupParams{
+		mysqld:      mysqld,
+		storage:     mysqld.backupStorage,
+		concurrency: concurrency,
+		name:        fmt.Sprintf("backup-%d", time.Now().UnixNano()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	manifest.Engine = engine
+	manifest.Position = pos
+	return manifest, nil
+}
+
+// Restore is part of the MysqlDaemon interface
+func (mysqld *Mysqld) Restore(ctx context.Context, manifest *BackupManifest) (proto.ReplicationPosition, error) {
+	if mysqld.backupStorage == nil {
+		return proto.ReplicationPosition{}, fmt.Errorf("no BackupStorage configured; call SetBackupStorage first")
+	}
+	eng, ok := backupEngines[manifest.Engine]
+	if !ok {
+		return proto.ReplicationPosition{}, fmt.Errorf("unknown backup engine %q in manifest, know: xtrabackup, builtin", manifest.Engine)
+	}
+	if err := eng.executeRestore(ctx, mysqld, manifest, mysqld.backupStorage); err != nil {
+		return proto.ReplicationPosition{}, err
+	}
+
+	// A physical restore brings back a data directory with no executed
+	// GTID history of its own. Reset it and seed gtid_purged with the
+	// position the backup was taken at, so the server's GTID state
+	// matches the manifest and the caller can CHANGE MASTER TO it.
+	if err := mysqld.ExecuteSuperQueryList([]string{
+		"RESET MASTER",
+		fmt.Sprintf("SET GLOBAL gtid_purged = '%v'", manifest.Position),
+	}); err != nil {
+		return proto.ReplicationPosition{}, fmt.Errorf("failed to seed GTID position after restore: %v", err)
+	}
+
+	return manifest.Position, nil
+}
+
+// xtrabackupEngine takes a hot physical backup by streaming the
+// xtrabackup tool's output straight into storage, and restores by
+// streaming it back out and preparing it in place.
+type xtrabackupEngine struct{}
+
+func (xtrabackupEngine) executeBackup(ctx context.Context, p backupParams) (*BackupManifest, error) {
+	path := p.name + ".xbstream"
+	w, err := p.storage.OpenForWrite(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup storage for write: %v", err)
+	}
+	defer w.Close()
+
+	cmd := exec.CommandContext(ctx, "xtrabackup",
+		"--backup",
+		"--stream=xbstream",
+		fmt.Sprintf("--parallel=%d", p.concurrency),
+		fmt.Sprintf("--datadir=%s", p.mysqld.config.DataDir))
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("xtrabackup --backup failed: %v", err)
+	}
+
+	return &BackupManifest{StorageURL: path}, nil
+}
+
+func (xtrabackupEngine) executeRestore(ctx context.Context, mysqld *Mysqld, manifest *BackupManifest, storage BackupStorage) error {
+	r, err := storage.OpenForRead(ctx, manifest.StorageURL)
+	if err != nil {
+		return fmt.Errorf("failed to open backup storage for read: %v", err)
+	}
+	defer r.Close()
+
+	extract := exec.CommandContext(ctx, "xbstream", "-x", "-C", mysqld.config.DataDir)
+	extract.Stdin = r
+	extract.Stderr = os.Stderr
+	if err := extract.Run(); err != nil {
+		return fmt.Errorf("xbstream extract failed: %v", err)
+	}
+
+	prepare := exec.CommandContext(ctx, "xtrabackup", "--prepare",
+		fmt.Sprintf("--target-dir=%s", mysqld.config.DataDir))
+	prepare.Stderr = os.Stderr
+	if err := prepare.Run(); err != nil {
+		return fmt.Errorf("xtrabackup --prepare failed: %v", err)
+	}
+	return nil
+}
+
+// builtinEngine takes a cold backup by taking a global read lock and
+// copying the data directory's files to storage one by one, with up to
+// concurrency files in flight at once.
+type builtinEngine struct{}
+
+func (builtinEngine) executeBackup(ctx context.Context, p backupParams) (*BackupManifest, error) {
+	// FLUSH TABLES WITH READ LOCK is session-scoped: it must be taken
+	// and released on the same connection, held open for the entire
+	// walk/copy, or the lock evaporates the moment the connection that
+	// took it closes and the backup is no longer consistent.
+	lockConn, err := p.mysqld.GetDbaConnection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open connection to hold the backup lock: %v", err)
+	}
+	defer lockConn.Close()
+	if _, err := lockConn.ExecuteFetch("FLUSH TABLES WITH READ LOCK", 0, false); err != nil {
+		return nil, fmt.Errorf("failed to lock tables for cold backup: %v", err)
+	}
+	defer lockConn.ExecuteFetch("UNLOCK TABLES", 0, false)
+
+	var (
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, p.concurrency)
+		mu       sync.Mutex
+		files    []string
+		firstErr error
+	)
+
+	walkErr := filepath.Walk(p.mysqld.config.DataDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(p.mysqld.config.DataDir, file)
+		if err != nil {
+			return err
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if copyErr := copyFileToStorage(ctx, p.storage, p.name+"/"+rel, file); copyErr != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = copyErr
+				}
+				mu.Unlock()
+				return
+			}
+			mu.Lock()
+			files = append(files, rel)
+			mu.Unlock()
+		}()
+		return nil
+	})
+	wg.Wait()
+
+	if walkErr != nil {
+		return nil, fmt.Errorf("failed to walk data directory: %v", walkErr)
+	}
+	if firstErr != nil {
+		return nil, fmt.Errorf("failed to copy data directory to backup storage: %v", firstErr)
+	}
+
+	return &BackupManifest{StorageURL: p.name, Files: files}, nil
+}
+
+func (builtinEngine) executeRestore(ctx context.Context, mysqld *Mysqld, manifest *BackupManifest, storage BackupStorage) error {
+	for _, rel := range manifest.Files {
+		if err := copyFileFromStorage(ctx, storage, manifest.StorageURL+"/"+rel, filepath.Join(mysqld.config.DataDir, rel)); err != nil {
+			return fmt.Errorf("failed to restore %v: %v", rel, err)
+		}
+	}
+	return nil
+}
+
+func copyFileToStorage(ctx context.Context, storage BackupStorage, dest, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := storage.OpenForWrite(ctx, dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+func copyFileFromStorage(ctx context.Context, storage BackupStorage, src, dest string) error {
+	in, err := storage.OpenForRead(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// LocalBackupStorage implements BackupStorage by writing backup files
+// under a root directory on local disk. It is the simplest storage
+// backend; S3BackupStorage and GCSBackupStorage below satisfy the same
+// interface without Backup/Restore needing to change.
+type LocalBackupStorage struct {
+	// Root is the directory backup paths are resolved relative to.
+	Root string
+}
+
+// OpenForWrite is part of the BackupStorage interface
+func (s *LocalBackupStorage) OpenForWrite(ctx context.Context, path string) (io.WriteCloser, error) {
+	full := filepath.Join(s.Root, path)
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(full)
+}
+
+// OpenForRead is part of the BackupStorage interface
+func (s *LocalBackupStorage) OpenForRead(ctx context.Context, path string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.Root, path))
+}
+
+// S3BackupStorage implements BackupStorage against an S3 bucket. Backup
+// paths are stored as keys under Prefix.
+type S3BackupStorage struct {
+	Bucket string
+	Prefix string
+
+	sess *session.Session
+}
+
+// NewS3BackupStorage returns an S3BackupStorage that uploads to and
+// downloads from bucket, namespacing every key under prefix. Credentials
+// and region come from the environment / shared AWS config, following
+// the aws-sdk-go default session conventions.
+func NewS3BackupStorage(bucket, prefix string) (*S3BackupStorage, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %v", err)
+	}
+	return &S3BackupStorage{Bucket: bucket, Prefix: prefix, sess: sess}, nil
+}
+
+func (s *S3BackupStorage) key(path string) string {
+	return strings.TrimPrefix(s.Prefix+"/"+path, "/")
+}
+
+// OpenForWrite is part of the BackupStorage interface. Since s3manager's
+// Uploader wants an io.Reader rather than something to be written to
+// incrementally, it streams through a pipe: writes to the returned
+// io.WriteCloser feed the pipe, and Close blocks until the upload
+// initiated on the other end finishes (or fails).
+func (s *S3BackupStorage) OpenForWrite(ctx context.Context, path string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	uploader := s3manager.NewUploader(s.sess)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket: aws.String(s.Bucket),
+			Key:    aws.String(s.key(path)),
+			Body:   pr,
+		})
+		pr.CloseWithError(err)
+		errc <- err
+	}()
+	return &s3UploadWriter{pw: pw, errc: errc}, nil
+}
+
+// OpenForRead is part of the BackupStorage interface
+func (s *S3BackupStorage) OpenForRead(ctx context.Context, path string) (io.ReadCloser, error) {
+	out, err := s3.New(s.sess).GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.key(path)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// s3UploadWriter adapts the write side of an io.Pipe to io.WriteCloser,
+// making Close wait for and report the result of the upload reading from
+// the other end of the pipe.
+type s3UploadWriter struct {
+	pw   *io.PipeWriter
+	errc chan error
+}
+
+func (w *s3UploadWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *s3UploadWriter) Close() error {
+	if err := w.pw.Close(); err != nil {
+		return err
+	}
+	return <-w.errc
+}
+
+// GCSBackupStorage implements BackupStorage against a Google Cloud
+// Storage bucket. Backup paths are stored as object names under Prefix.
+type GCSBackupStorage struct {
+	Bucket string
+	Prefix string
+
+	client *storage.Client
+}
+
+// NewGCSBackupStorage returns a GCSBackupStorage that uploads to and
+// downloads from bucket, namespacing every object under prefix.
+// Credentials come from the environment, following the Google Cloud
+// client library's default application-credentials conventions.
+func NewGCSBackupStorage(ctx context.Context, bucket, prefix string) (*GCSBackupStorage, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %v", err)
+	}
+	return &GCSBackupStorage{Bucket: bucket, Prefix: prefix, client: client}, nil
+}
+
+func (s *GCSBackupStorage) object(path string) string {
+	return strings.TrimPrefix(s.Prefix+"/"+path, "/")
+}
+
+// OpenForWrite is part of the BackupStorage interface
+func (s *GCSBackupStorage) OpenForWrite(ctx context.Context, path string) (io.WriteCloser, error) {
+	return s.client.Bucket(s.Bucket).Object(s.object(path)).NewWriter(ctx), nil
+}
+
+// OpenForRead is part of the BackupStorage interface
+func (s *GCSBackupStorage) OpenForRead(ctx context.Context, path string) (io.ReadCloser, error) {
+	return s.client.Bucket(s.Bucket).Object(s.object(path)).NewReader(ctx)
+}
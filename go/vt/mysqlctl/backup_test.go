@@ -0,0 +1,48 @@
+// Copyright 2012, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestLocalBackupStorageRoundTrip(t *testing.T) {
+	root, err := ioutil.TempDir("", "mysqlctl_backup_test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	storage := &LocalBackupStorage{Root: root}
+	ctx := context.Background()
+
+	w, err := storage.OpenForWrite(ctx, "backup-1/ibdata1")
+	if err != nil {
+		t.Fatalf("OpenForWrite failed: %v", err)
+	}
+	if _, err := w.Write([]byte("fake innodb data")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	r, err := storage.OpenForRead(ctx, "backup-1/ibdata1")
+	if err != nil {
+		t.Fatalf("OpenForRead failed: %v", err)
+	}
+	defer r.Close()
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if string(got) != "fake innodb data" {
+		t.Errorf("read back %q, want %q", got, "fake innodb data")
+	}
+}
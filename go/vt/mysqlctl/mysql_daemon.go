@@ -6,6 +6,7 @@ package mysqlctl
 
 import (
 	"fmt"
+	"io"
 	"reflect"
 	"strings"
 	"time"
@@ -34,6 +35,19 @@ type MysqlDaemon interface {
 	// replication related methods
 	SlaveStatus() (proto.ReplicationStatus, error)
 
+	// SemiSyncEnabled returns whether the master and/or slave sides
+	// of semi-sync replication are currently enabled.
+	SemiSyncEnabled() (master, slave bool, err error)
+
+	// SetSemiSyncEnabled enables or disables the master and slave
+	// sides of semi-sync replication.
+	SetSemiSyncEnabled(master, slave bool) error
+
+	// SemiSyncStatus returns the number of semi-sync clients the
+	// master is currently waiting on, and the average round-trip
+	// time reported by the semi-sync master plugin.
+	SemiSyncStatus() (clientsCount int, netAvg time.Duration, err error)
+
 	// reparenting related methods
 	ResetReplicationCommands() ([]string, error)
 	MasterPosition() (proto.ReplicationPosition, error)
@@ -59,6 +73,43 @@ type MysqlDaemon interface {
 	PreflightSchemaChange(dbName string, change string) (*proto.SchemaChangeResult, error)
 	ApplySchemaChange(dbName string, change *proto.SchemaChange) (*proto.SchemaChangeResult, error)
 
+	// ApplyOnlineSchemaChange starts a non-blocking ALTER on dbName
+	// using a shadow table and change-propagating triggers: rows are
+	// chunk-copied into the shadow table in PK order while AFTER
+	// INSERT/UPDATE/DELETE triggers on the original table mirror
+	// concurrent writes, and the copy throttles itself against
+	// replication lag. It returns a migration ID used to poll
+	// OnlineSchemaChangeStatus and to call
+	// CutoverOnlineSchemaChange / AbortOnlineSchemaChange.
+	ApplyOnlineSchemaChange(dbName string, change *proto.SchemaChange) (migrationID string, err error)
+
+	// OnlineSchemaChangeStatus returns the current progress of a
+	// migration started by ApplyOnlineSchemaChange.
+	OnlineSchemaChangeStatus(migrationID string) (*OnlineSchemaChangeStatus, error)
+
+	// CutoverOnlineSchemaChange atomically renames the shadow table
+	// into place, ending the migration. It does not wait: it returns
+	// an error if the row copy has not finished yet.
+	CutoverOnlineSchemaChange(migrationID string) error
+
+	// AbortOnlineSchemaChange cancels an in-progress migration and
+	// drops its shadow table and triggers.
+	AbortOnlineSchemaChange(migrationID string) error
+
+	// Backup related methods
+
+	// Backup creates a backup of the whole instance using the named
+	// backup engine ("xtrabackup" for a hot physical backup, "builtin"
+	// for a cold file copy), uploading it through the configured
+	// BackupStorage with up to concurrency parallel file transfers.
+	// The returned manifest records the GTID position at backup time,
+	// so a later Restore knows where to resume replication from.
+	Backup(ctx context.Context, engine string, concurrency int) (*BackupManifest, error)
+
+	// Restore restores the server from manifest, leaving it ready to
+	// CHANGE MASTER TO at the GTID position the backup was taken at.
+	Restore(ctx context.Context, manifest *BackupManifest) (proto.ReplicationPosition, error)
+
 	// GetAppConnection returns a app connection to be able to talk to the database.
 	GetAppConnection() (dbconnpool.PoolConnection, error)
 	// GetDbaConnection returns a dba connection.
@@ -84,6 +135,64 @@ type MysqlDaemon interface {
 	Close()
 }
 
+// OnlineSchemaChangeStatus describes the progress of a migration
+// started by MysqlDaemon.ApplyOnlineSchemaChange.
+type OnlineSchemaChangeStatus struct {
+	// RowsCopied is the number of rows copied into the shadow table so far.
+	RowsCopied int64
+
+	// RowsTotal is an estimate of the total number of rows to copy.
+	RowsTotal int64
+
+	// LastPK is the last primary key value successfully copied. It is
+	// also written to the migration's progress table, but nothing reads
+	// it back: a migration's copy always starts at the first row.
+	LastPK string
+
+	// Throttled is true if copying is currently paused because
+	// Seconds_Behind_Master on a slave exceeded the configured threshold.
+	Throttled bool
+
+	// Done is true once all rows have been copied and the shadow
+	// table is ready to be cut over.
+	Done bool
+}
+
+// BackupManifest records the metadata of a single backup created by
+// MysqlDaemon.Backup, as needed by MysqlDaemon.Restore to bring the
+// restored server back into the replication stream.
+type BackupManifest struct {
+	// Engine is the backup engine that produced this backup
+	// ("xtrabackup" or "builtin").
+	Engine string
+
+	// StorageURL is where the backup data was written, e.g. a local
+	// path, or an s3:// / gs:// URL, interpreted by a BackupStorage.
+	StorageURL string
+
+	// Position is the GTID position of the server at the time the
+	// backup was taken.
+	Position proto.ReplicationPosition
+
+	// Files lists the backup's contents as paths relative to
+	// StorageURL. It is used by engines (like "builtin") that back up
+	// many individual files rather than a single opaque stream, so
+	// Restore knows what to read back.
+	Files []string
+}
+
+// BackupStorage abstracts the object store a backup is written to and
+// read back from, so Backup/Restore can target local disk, S3, GCS,
+// or other backends without knowing which.
+type BackupStorage interface {
+	// OpenForWrite returns a writer to store a new backup file at path.
+	OpenForWrite(ctx context.Context, path string) (io.WriteCloser, error)
+
+	// OpenForRead returns a reader for a backup file previously
+	// written with OpenForWrite.
+	OpenForRead(ctx context.Context, path string) (io.ReadCloser, error)
+}
+
 // FakeMysqlDaemon implements MysqlDaemon and allows the user to fake
 // everything.
 type FakeMysqlDaemon struct {
@@ -121,6 +230,22 @@ type FakeMysqlDaemon struct {
 	// ReadOnly is the current value of the flag
 	ReadOnly bool
 
+	// SemiSyncMasterEnabled represents the current value of the
+	// rpl_semi_sync_master_enabled flag, as set by SetSemiSyncEnabled
+	// and returned by SemiSyncEnabled.
+	SemiSyncMasterEnabled bool
+
+	// SemiSyncSlaveEnabled represents the current value of the
+	// rpl_semi_sync_slave_enabled flag, as set by SetSemiSyncEnabled
+	// and returned by SemiSyncEnabled.
+	SemiSyncSlaveEnabled bool
+
+	// SemiSyncClientsCount is returned by SemiSyncStatus
+	SemiSyncClientsCount int
+
+	// SemiSyncNetAvg is returned by SemiSyncStatus
+	SemiSyncNetAvg time.Duration
+
 	// StartReplicationCommandsStatus is matched against the input
 	// of StartReplicationCommands. If it doesn't match,
 	// StartReplicationCommands will return an error.
@@ -161,6 +286,71 @@ type FakeMysqlDaemon struct {
 	// If nil we'll return an error.
 	ApplySchemaChangeResult *proto.SchemaChangeResult
 
+	// ApplyOnlineSchemaChangeMigrationID is returned by
+	// ApplyOnlineSchemaChange. If empty, ApplyOnlineSchemaChange
+	// returns an error.
+	ApplyOnlineSchemaChangeMigrationID string
+
+	// ApplyOnlineSchemaChangeError is returned by ApplyOnlineSchemaChange.
+	ApplyOnlineSchemaChangeError error
+
+	// OnlineSchemaChangeChunkBoundaries is the expected sequence of PK
+	// chunk boundaries the copy is driven through, keyed by migration
+	// ID. AdvanceOnlineSchemaChangeChunk consumes these one at a time,
+	// so tests can assert on them directly and control exactly how
+	// many chunks a migration has copied.
+	OnlineSchemaChangeChunkBoundaries map[string][]string
+
+	// OnlineSchemaChangeChunkIndex is the index of the next boundary
+	// AdvanceOnlineSchemaChangeChunk will consume for each migration ID.
+	OnlineSchemaChangeChunkIndex map[string]int
+
+	// OnlineSchemaChangeCopyEvents records, per migration ID, the
+	// sequence of chunk-copy events as AdvanceOnlineSchemaChangeChunk
+	// drives the state machine, so tests can assert on the order they
+	// happened in.
+	OnlineSchemaChangeCopyEvents map[string][]string
+
+	// OnlineSchemaChangeStatuses holds the status returned by
+	// OnlineSchemaChangeStatus, keyed by migration ID. It is kept in
+	// sync by AdvanceOnlineSchemaChangeChunk as chunks are consumed.
+	OnlineSchemaChangeStatuses map[string]*OnlineSchemaChangeStatus
+
+	// OnlineSchemaChangeCutovers records the migration IDs that
+	// CutoverOnlineSchemaChange was called on, in call order.
+	OnlineSchemaChangeCutovers []string
+
+	// OnlineSchemaChangeAborts records the migration IDs that
+	// AbortOnlineSchemaChange was called on, in call order.
+	OnlineSchemaChangeAborts []string
+
+	// ExpectedBackupEngine is matched against the engine argument of
+	// Backup. If it doesn't match, Backup returns an error.
+	ExpectedBackupEngine string
+
+	// ExpectedBackupConcurrency, if non-zero, is matched against the
+	// concurrency argument of Backup. If it doesn't match, Backup
+	// returns an error.
+	ExpectedBackupConcurrency int
+
+	// BackupResult is the manifest returned by Backup, recording the
+	// expected backup engine and storage URL for the test. If nil,
+	// Backup returns an error.
+	BackupResult *BackupManifest
+
+	// BackupError is returned by Backup.
+	BackupError error
+
+	// ExpectedRestoreManifest is matched against the manifest passed
+	// to Restore. If it doesn't match, Restore returns an error.
+	ExpectedRestoreManifest *BackupManifest
+
+	// RestorePosition is returned by Restore.
+	RestorePosition proto.ReplicationPosition
+
+	// RestoreError is returned by Restore.
+	RestoreError error
+
 	// DbAppConnectionFactory is the factory for making fake db app connections
 	DbAppConnectionFactory func() (dbconnpool.PoolConnection, error)
 
@@ -238,6 +428,23 @@ func (fmd *FakeMysqlDaemon) SlaveStatus() (proto.ReplicationStatus, error) {
 	}, nil
 }
 
+// SemiSyncEnabled is part of the MysqlDaemon interface
+func (fmd *FakeMysqlDaemon) SemiSyncEnabled() (master, slave bool, err error) {
+	return fmd.SemiSyncMasterEnabled, fmd.SemiSyncSlaveEnabled, nil
+}
+
+// SetSemiSyncEnabled is part of the MysqlDaemon interface
+func (fmd *FakeMysqlDaemon) SetSemiSyncEnabled(master, slave bool) error {
+	fmd.SemiSyncMasterEnabled = master
+	fmd.SemiSyncSlaveEnabled = slave
+	return nil
+}
+
+// SemiSyncStatus is part of the MysqlDaemon interface
+func (fmd *FakeMysqlDaemon) SemiSyncStatus() (clientsCount int, netAvg time.Duration, err error) {
+	return fmd.SemiSyncClientsCount, fmd.SemiSyncNetAvg, nil
+}
+
 // ResetReplicationCommands is part of the MysqlDaemon interface
 func (fmd *FakeMysqlDaemon) ResetReplicationCommands() ([]string, error) {
 	return fmd.ResetReplicationResult, fmd.ResetReplicationError
@@ -407,6 +614,112 @@ func (fmd *FakeMysqlDaemon) ApplySchemaChange(dbName string, change *proto.Schem
 	return fmd.ApplySchemaChangeResult, nil
 }
 
+// ApplyOnlineSchemaChange is part of the MysqlDaemon interface
+func (fmd *FakeMysqlDaemon) ApplyOnlineSchemaChange(dbName string, change *proto.SchemaChange) (string, error) {
+	if fmd.ApplyOnlineSchemaChangeError != nil {
+		return "", fmd.ApplyOnlineSchemaChangeError
+	}
+	if fmd.ApplyOnlineSchemaChangeMigrationID == "" {
+		return "", fmt.Errorf("no online schema change migration id defined")
+	}
+	return fmd.ApplyOnlineSchemaChangeMigrationID, nil
+}
+
+// OnlineSchemaChangeStatus is part of the MysqlDaemon interface
+func (fmd *FakeMysqlDaemon) OnlineSchemaChangeStatus(migrationID string) (*OnlineSchemaChangeStatus, error) {
+	status, ok := fmd.OnlineSchemaChangeStatuses[migrationID]
+	if !ok {
+		return nil, fmt.Errorf("unknown online schema change migration: %v", migrationID)
+	}
+	return status, nil
+}
+
+// CutoverOnlineSchemaChange is part of the MysqlDaemon interface
+func (fmd *FakeMysqlDaemon) CutoverOnlineSchemaChange(migrationID string) error {
+	status, ok := fmd.OnlineSchemaChangeStatuses[migrationID]
+	if !ok || !status.Done {
+		return fmt.Errorf("online schema change migration %v has not finished copying rows yet", migrationID)
+	}
+	fmd.OnlineSchemaChangeCutovers = append(fmd.OnlineSchemaChangeCutovers, migrationID)
+	return nil
+}
+
+// AbortOnlineSchemaChange is part of the MysqlDaemon interface
+func (fmd *FakeMysqlDaemon) AbortOnlineSchemaChange(migrationID string) error {
+	fmd.OnlineSchemaChangeAborts = append(fmd.OnlineSchemaChangeAborts, migrationID)
+	return nil
+}
+
+// AdvanceOnlineSchemaChangeChunk drives the fake copy state machine
+// forward by one chunk for migrationID: it consumes the next entry from
+// OnlineSchemaChangeChunkBoundaries, appends it to
+// OnlineSchemaChangeCopyEvents, and updates OnlineSchemaChangeStatuses,
+// marking the migration Done once all boundaries have been consumed.
+// This is what lets a test drive ApplyOnlineSchemaChange /
+// OnlineSchemaChangeStatus / CutoverOnlineSchemaChange /
+// AbortOnlineSchemaChange through a deterministic sequence of chunks.
+func (fmd *FakeMysqlDaemon) AdvanceOnlineSchemaChangeChunk(migrationID string) error {
+	boundaries := fmd.OnlineSchemaChangeChunkBoundaries[migrationID]
+	if fmd.OnlineSchemaChangeChunkIndex == nil {
+		fmd.OnlineSchemaChangeChunkIndex = make(map[string]int)
+	}
+	index := fmd.OnlineSchemaChangeChunkIndex[migrationID]
+	if index >= len(boundaries) {
+		return fmt.Errorf("no more chunk boundaries for migration %v", migrationID)
+	}
+	boundary := boundaries[index]
+	fmd.OnlineSchemaChangeChunkIndex[migrationID] = index + 1
+
+	if fmd.OnlineSchemaChangeCopyEvents == nil {
+		fmd.OnlineSchemaChangeCopyEvents = make(map[string][]string)
+	}
+	fmd.OnlineSchemaChangeCopyEvents[migrationID] = append(fmd.OnlineSchemaChangeCopyEvents[migrationID], boundary)
+
+	if fmd.OnlineSchemaChangeStatuses == nil {
+		fmd.OnlineSchemaChangeStatuses = make(map[string]*OnlineSchemaChangeStatus)
+	}
+	status, ok := fmd.OnlineSchemaChangeStatuses[migrationID]
+	if !ok {
+		status = &OnlineSchemaChangeStatus{}
+		fmd.OnlineSchemaChangeStatuses[migrationID] = status
+	}
+	status.LastPK = boundary
+	status.RowsCopied++
+	status.Done = fmd.OnlineSchemaChangeChunkIndex[migrationID] >= len(boundaries)
+	return nil
+}
+
+// Backup is part of the MysqlDaemon interface
+func (fmd *FakeMysqlDaemon) Backup(ctx context.Context, engine string, concurrency int) (*BackupManifest, error) {
+	if fmd.BackupError != nil {
+		return nil, fmd.BackupError
+	}
+	if fmd.ExpectedBackupEngine != engine {
+		return nil, fmt.Errorf("wrong engine for Backup: expected %v got %v", fmd.ExpectedBackupEngine, engine)
+	}
+	if concurrency < 1 {
+		return nil, fmt.Errorf("wrong concurrency for Backup: got %v, want >= 1", concurrency)
+	}
+	if fmd.ExpectedBackupConcurrency != 0 && fmd.ExpectedBackupConcurrency != concurrency {
+		return nil, fmt.Errorf("wrong concurrency for Backup: expected %v got %v", fmd.ExpectedBackupConcurrency, concurrency)
+	}
+	if fmd.BackupResult == nil {
+		return nil, fmt.Errorf("no backup result defined")
+	}
+	return fmd.BackupResult, nil
+}
+
+// Restore is part of the MysqlDaemon interface
+func (fmd *FakeMysqlDaemon) Restore(ctx context.Context, manifest *BackupManifest) (proto.ReplicationPosition, error) {
+	if fmd.RestoreError != nil {
+		return proto.ReplicationPosition{}, fmd.RestoreError
+	}
+	if !reflect.DeepEqual(fmd.ExpectedRestoreManifest, manifest) {
+		return proto.ReplicationPosition{}, fmt.Errorf("wrong manifest for Restore: expected %v got %v", fmd.ExpectedRestoreManifest, manifest)
+	}
+	return fmd.RestorePosition, nil
+}
+
 // GetAppConnection is part of the MysqlDaemon interface
 func (fmd *FakeMysqlDaemon) GetAppConnection() (dbconnpool.PoolConnection, error) {
 	if fmd.DbAppConnectionFactory == nil {
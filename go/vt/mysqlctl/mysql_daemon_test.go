@@ -0,0 +1,43 @@
+// Copyright 2012, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeMysqlDaemonSemiSync(t *testing.T) {
+	fmd := NewFakeMysqlDaemon()
+
+	master, slave, err := fmd.SemiSyncEnabled()
+	if err != nil {
+		t.Fatalf("SemiSyncEnabled failed: %v", err)
+	}
+	if master || slave {
+		t.Errorf("new FakeMysqlDaemon should start with semi-sync disabled, got master=%v slave=%v", master, slave)
+	}
+
+	if err := fmd.SetSemiSyncEnabled(true, false); err != nil {
+		t.Fatalf("SetSemiSyncEnabled failed: %v", err)
+	}
+	master, slave, err = fmd.SemiSyncEnabled()
+	if err != nil {
+		t.Fatalf("SemiSyncEnabled failed: %v", err)
+	}
+	if !master || slave {
+		t.Errorf("SetSemiSyncEnabled(true, false) should result in master=true slave=false, got master=%v slave=%v", master, slave)
+	}
+
+	fmd.SemiSyncClientsCount = 3
+	fmd.SemiSyncNetAvg = 150 * time.Microsecond
+	clientsCount, netAvg, err := fmd.SemiSyncStatus()
+	if err != nil {
+		t.Fatalf("SemiSyncStatus failed: %v", err)
+	}
+	if clientsCount != 3 || netAvg != 150*time.Microsecond {
+		t.Errorf("SemiSyncStatus returned clientsCount=%v netAvg=%v, want 3 and 150us", clientsCount, netAvg)
+	}
+}
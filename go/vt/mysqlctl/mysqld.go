@@ -0,0 +1,176 @@
+// Copyright 2012, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqldb"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/dbconnpool"
+	"golang.org/x/net/context"
+)
+
+// Mysqld is the real implementation of MysqlDaemon, backed by a running
+// mysqld instance. Unlike FakeMysqlDaemon, its queries go over real
+// connections made with its dbaParams and appParams. Some methods
+// (Start, NewSlaveConnection, WaitForReparentJournal, WaitMasterPos and
+// the GetSchema / PreflightSchemaChange / ApplySchemaChange group) are
+// not implemented in this build and return an error.
+type Mysqld struct {
+	config *Mycnf
+
+	dbaParams *sqldb.ConnParams
+	appParams *sqldb.ConnParams
+
+	dbaPoolStats *stats.Timings
+	appPoolStats *stats.Timings
+
+	// durability is consulted by PromoteSlave and SetMasterCommands to
+	// decide how semi-sync should be configured for this tablet's new
+	// role. It is nil until SetDurabilityPolicy is called, in which
+	// case semi-sync is left untouched during reparents.
+	durability DurabilityPolicy
+
+	mu sync.Mutex
+	// onlineSchemaChanges tracks in-flight migrations started by
+	// ApplyOnlineSchemaChange, keyed by migration ID.
+	onlineSchemaChanges map[string]*onlineSchemaChangeMigration
+
+	// backupStorage is where Backup uploads to and Restore downloads
+	// from. It is nil until SetBackupStorage is called, in which case
+	// Backup and Restore return an error.
+	backupStorage BackupStorage
+}
+
+// SetBackupStorage configures where Backup uploads backups to and
+// Restore downloads them from.
+func (mysqld *Mysqld) SetBackupStorage(storage BackupStorage) {
+	mysqld.backupStorage = storage
+}
+
+// NewMysqld returns a Mysqld that talks to the mysqld instance described
+// by config, using dbaParams for administrative connections (replication
+// control, schema changes, backups) and appParams for GetAppConnection.
+func NewMysqld(config *Mycnf, dbaParams, appParams *sqldb.ConnParams) *Mysqld {
+	return &Mysqld{
+		config:              config,
+		dbaParams:           dbaParams,
+		appParams:           appParams,
+		dbaPoolStats:        stats.NewTimings("MysqldDbaConnection"),
+		appPoolStats:        stats.NewTimings("MysqldAppConnection"),
+		onlineSchemaChanges: make(map[string]*onlineSchemaChangeMigration),
+	}
+}
+
+// Cnf is part of the MysqlDaemon interface
+func (mysqld *Mysqld) Cnf() *Mycnf {
+	return mysqld.config
+}
+
+// Start is part of the MysqlDaemon interface
+func (mysqld *Mysqld) Start(ctx context.Context) error {
+	return fmt.Errorf("Start is not implemented for this build of mysqlctl; it requires starting the mysqld_safe process for %v", mysqld.config)
+}
+
+// Shutdown is part of the MysqlDaemon interface
+func (mysqld *Mysqld) Shutdown(ctx context.Context, waitForMysqld bool) error {
+	return mysqld.ExecuteSuperQueryList([]string{"SHUTDOWN"})
+}
+
+// RunMysqlUpgrade is part of the MysqlDaemon interface
+func (mysqld *Mysqld) RunMysqlUpgrade() error {
+	return nil
+}
+
+// GetMysqlPort is part of the MysqlDaemon interface
+func (mysqld *Mysqld) GetMysqlPort() (int, error) {
+	fields, err := mysqld.fetchSuperQueryMap("SHOW VARIABLES LIKE 'port'")
+	if err != nil {
+		return 0, err
+	}
+	port, err := strconv.Atoi(fields["Value"])
+	if err != nil {
+		return 0, fmt.Errorf("can't parse mysql port %v: %v", fields["Value"], err)
+	}
+	return port, nil
+}
+
+// GetAppConnection is part of the MysqlDaemon interface
+func (mysqld *Mysqld) GetAppConnection() (dbconnpool.PoolConnection, error) {
+	return dbconnpool.NewDBConnection(mysqld.appParams, mysqld.appPoolStats)
+}
+
+// GetDbaConnection is part of the MysqlDaemon interface.
+func (mysqld *Mysqld) GetDbaConnection() (*dbconnpool.DBConnection, error) {
+	return dbconnpool.NewDBConnection(mysqld.dbaParams, mysqld.dbaPoolStats)
+}
+
+// ExecuteSuperQueryList is part of the MysqlDaemon interface
+func (mysqld *Mysqld) ExecuteSuperQueryList(queryList []string) error {
+	conn, err := mysqld.GetDbaConnection()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, query := range queryList {
+		if _, err := conn.ExecuteFetch(query, 0, false); err != nil {
+			return fmt.Errorf("ExecuteFetch(%v) failed: %v", query, err)
+		}
+	}
+	return nil
+}
+
+// FetchSuperQuery is part of the MysqlDaemon interface
+func (mysqld *Mysqld) FetchSuperQuery(query string) (*mproto.QueryResult, error) {
+	conn, err := mysqld.GetDbaConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.ExecuteFetch(query, 10000, true)
+}
+
+// fetchSuperQueryMap runs query, which is expected to return exactly one
+// row, and returns that row as a map from column name to string value.
+// It is the basis for parsing SHOW VARIABLES / SHOW STATUS / SHOW SLAVE
+// STATUS style output used throughout this package.
+func (mysqld *Mysqld) fetchSuperQueryMap(query string) (map[string]string, error) {
+	qr, err := mysqld.FetchSuperQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(qr.Rows) != 1 {
+		return nil, fmt.Errorf("query %#v returned %v rows, expected 1", query, len(qr.Rows))
+	}
+	result := make(map[string]string, len(qr.Fields))
+	for i, field := range qr.Fields {
+		result[field.Name] = qr.Rows[0][i].String()
+	}
+	return result, nil
+}
+
+// NewSlaveConnection is part of the MysqlDaemon interface
+func (mysqld *Mysqld) NewSlaveConnection() (*SlaveConnection, error) {
+	return nil, fmt.Errorf("NewSlaveConnection is not implemented for this build of mysqlctl")
+}
+
+// EnableBinlogPlayback is part of the MysqlDaemon interface
+func (mysqld *Mysqld) EnableBinlogPlayback() error {
+	return nil
+}
+
+// DisableBinlogPlayback is part of the MysqlDaemon interface
+func (mysqld *Mysqld) DisableBinlogPlayback() error {
+	return nil
+}
+
+// Close is part of the MysqlDaemon interface
+func (mysqld *Mysqld) Close() {
+}
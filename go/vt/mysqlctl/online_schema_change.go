@@ -0,0 +1,563 @@
+// Copyright 2012, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/mysqlctl/proto"
+)
+
+const (
+	// onlineSchemaChangeChunkSize is the number of rows copied from the
+	// original table to the shadow table per chunk.
+	onlineSchemaChangeChunkSize = 1000
+
+	// onlineSchemaChangeCopyInterval is how long the copy loop sleeps
+	// between chunks, so it doesn't monopolize the master.
+	onlineSchemaChangeCopyInterval = 100 * time.Millisecond
+
+	// onlineSchemaChangeLagThreshold is the Seconds_Behind_Master value
+	// above which chunk copying pauses until a slave catches back up.
+	onlineSchemaChangeLagThreshold = 10
+
+	// onlineSchemaChangeLagCheckInterval is how long the copy loop waits
+	// before re-checking replication lag while throttled.
+	onlineSchemaChangeLagCheckInterval = 5 * time.Second
+
+	// onlineSchemaChangeLockWaitRetries is how many times a chunk copy
+	// retries after a transient "Lock wait timeout" before giving up and
+	// failing the migration.
+	onlineSchemaChangeLockWaitRetries = 3
+)
+
+// onlineSchemaChangeRowsCopied publishes, per migration ID, how many rows
+// have been copied into the shadow table so far. OnlineSchemaChangeStatus
+// also reports this, but as a stats.Counters it is scrapeable without
+// polling every in-flight migration individually.
+var onlineSchemaChangeRowsCopied = stats.NewCounters("OnlineSchemaChangeRowsCopied")
+
+// numericColumnTypes are INFORMATION_SCHEMA.COLUMNS DATA_TYPE values whose
+// values are safe to interpolate into SQL unquoted. Anything else (chars,
+// text, binary, dates, ...) must be quoted and escaped.
+var numericColumnTypes = map[string]bool{
+	"tinyint": true, "smallint": true, "mediumint": true, "int": true, "integer": true,
+	"bigint": true, "decimal": true, "numeric": true, "float": true, "double": true,
+	"bit": true, "year": true,
+}
+
+// quoteColumnValue renders value as a SQL literal suitable for column
+// colType: unquoted for numeric types, single-quoted and escaped for
+// everything else (VARCHAR, CHAR, TEXT, BINARY, DATE/DATETIME, ...).
+func quoteColumnValue(colType, value string) string {
+	if numericColumnTypes[colType] {
+		return value
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// isLockWaitTimeout reports whether err looks like MySQL's "Lock wait
+// timeout exceeded" error, which is transient and worth retrying rather
+// than failing the whole migration over.
+func isLockWaitTimeout(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Lock wait timeout")
+}
+
+var alterTableRE = regexp.MustCompile("(?i)^\\s*alter\\s+table\\s+`?([0-9A-Za-z_$]+)`?")
+
+var onlineSchemaChangeIDGen uint64
+
+// newOnlineSchemaChangeID returns a process-unique migration ID. IDs only
+// need to be unique for the lifetime of this Mysqld, since migrations are
+// forgotten once they are cut over or aborted.
+func newOnlineSchemaChangeID() string {
+	return fmt.Sprintf("osc_%d", atomic.AddUint64(&onlineSchemaChangeIDGen, 1))
+}
+
+// onlineSchemaChangeMigration tracks the state of a single in-flight
+// migration started by Mysqld.ApplyOnlineSchemaChange.
+type onlineSchemaChangeMigration struct {
+	id          string
+	dbName      string
+	table       string
+	shadowTable string
+
+	mu      sync.Mutex
+	status  OnlineSchemaChangeStatus
+	aborted bool
+	err     error
+	done    chan struct{}
+}
+
+func (m *onlineSchemaChangeMigration) snapshot() OnlineSchemaChangeStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+func (m *onlineSchemaChangeMigration) isDone() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status.Done
+}
+
+func (m *onlineSchemaChangeMigration) lastPK() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status.LastPK
+}
+
+func (m *onlineSchemaChangeMigration) setTotal(total int64) {
+	m.mu.Lock()
+	m.status.RowsTotal = total
+	m.mu.Unlock()
+}
+
+func (m *onlineSchemaChangeMigration) setThrottled(throttled bool) {
+	m.mu.Lock()
+	m.status.Throttled = throttled
+	m.mu.Unlock()
+}
+
+func (m *onlineSchemaChangeMigration) recordChunk(lastPK string, rowsCopied int64) {
+	m.mu.Lock()
+	m.status.LastPK = lastPK
+	m.status.RowsCopied += rowsCopied
+	m.mu.Unlock()
+}
+
+func (m *onlineSchemaChangeMigration) finish() {
+	m.mu.Lock()
+	m.status.Done = true
+	m.mu.Unlock()
+	close(m.done)
+}
+
+// fail records err as the reason the copy loop stopped and unblocks
+// anyone waiting on the migration (e.g. a concurrent Abort).
+func (m *onlineSchemaChangeMigration) fail(err error) {
+	m.mu.Lock()
+	m.err = err
+	m.mu.Unlock()
+	close(m.done)
+}
+
+func (m *onlineSchemaChangeMigration) lastError() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+func (m *onlineSchemaChangeMigration) isAborted() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.aborted
+}
+
+func (m *onlineSchemaChangeMigration) abort() {
+	m.mu.Lock()
+	m.aborted = true
+	m.mu.Unlock()
+}
+
+// finishAborted unblocks AbortOnlineSchemaChange without marking the
+// migration Done, since Done means "ready to cut over", not "stopped".
+func (m *onlineSchemaChangeMigration) finishAborted() {
+	close(m.done)
+}
+
+// shadowTableName returns the name of the shadow table created to mirror
+// table while a migration is in flight.
+func shadowTableName(table string) string {
+	return "_" + table + "_osc_new"
+}
+
+// oldTableName returns the name the original table is renamed to at
+// cutover time, right before it is dropped.
+func oldTableName(table string) string {
+	return "_" + table + "_osc_old"
+}
+
+// progressTableName returns the name of the sidecar table a migration
+// persists its last-copied PK and row count to, so progress survives a
+// Mysqld restart even though the in-memory onlineSchemaChangeMigration
+// does not.
+func progressTableName(table string) string {
+	return "_" + table + "_osc_progress"
+}
+
+// triggerName returns the name of one of the three copy triggers
+// installed on the original table for a migration.
+func triggerName(table, event string) string {
+	return fmt.Sprintf("_%s_osc_%s", table, event)
+}
+
+// rewriteAlterTableName rewrites "ALTER TABLE `orig` ..." into
+// "ALTER TABLE `shadow` ...", so the same ALTER the caller asked for on
+// the original table can be replayed against the shadow table instead.
+func rewriteAlterTableName(sql, shadowTable string) (string, error) {
+	if !alterTableRE.MatchString(sql) {
+		return "", fmt.Errorf("ApplyOnlineSchemaChange only supports ALTER TABLE statements, got: %v", sql)
+	}
+	return alterTableRE.ReplaceAllString(sql, "ALTER TABLE `"+shadowTable+"`"), nil
+}
+
+// ApplyOnlineSchemaChange is part of the MysqlDaemon interface
+func (mysqld *Mysqld) ApplyOnlineSchemaChange(dbName string, change *proto.SchemaChange) (string, error) {
+	m := alterTableRE.FindStringSubmatch(change.Sql)
+	if m == nil {
+		return "", fmt.Errorf("ApplyOnlineSchemaChange only supports ALTER TABLE statements, got: %v", change.Sql)
+	}
+	table := m[1]
+	shadow := shadowTableName(table)
+
+	shadowAlter, err := rewriteAlterTableName(change.Sql, shadow)
+	if err != nil {
+		return "", err
+	}
+
+	createAndAlter := []string{
+		fmt.Sprintf("CREATE TABLE `%s`.`%s` LIKE `%s`.`%s`", dbName, shadow, dbName, table),
+		shadowAlter,
+		fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS `%s`.`%s` (migration_id VARCHAR(64) PRIMARY KEY, last_pk VARCHAR(2048) NOT NULL, rows_copied BIGINT NOT NULL)",
+			dbName, progressTableName(table)),
+	}
+	if err := mysqld.ExecuteSuperQueryList(createAndAlter); err != nil {
+		return "", fmt.Errorf("failed to create shadow table %v: %v", shadow, err)
+	}
+
+	pkColumns, err := mysqld.primaryKeyColumns(dbName, table)
+	if err != nil {
+		mysqld.ExecuteSuperQueryList([]string{fmt.Sprintf("DROP TABLE `%s`.`%s`", dbName, shadow)})
+		return "", err
+	}
+
+	origColumns, colTypes, err := mysqld.tableColumns(dbName, table)
+	if err != nil {
+		mysqld.ExecuteSuperQueryList([]string{fmt.Sprintf("DROP TABLE `%s`.`%s`", dbName, shadow)})
+		return "", err
+	}
+	shadowColumns, _, err := mysqld.tableColumns(dbName, shadow)
+	if err != nil {
+		mysqld.ExecuteSuperQueryList([]string{fmt.Sprintf("DROP TABLE `%s`.`%s`", dbName, shadow)})
+		return "", err
+	}
+	// The shadow table already has the post-ALTER schema, so any ALTER
+	// that adds or drops columns leaves it with a different column set
+	// than the original. Only copy columns both tables still have;
+	// newly-added columns take their default/generated value instead,
+	// and dropped columns simply aren't read.
+	sharedCols := sharedColumns(origColumns, shadowColumns)
+
+	if err := mysqld.ExecuteSuperQueryList(installTriggerCommands(dbName, table, shadow, pkColumns, sharedCols)); err != nil {
+		mysqld.ExecuteSuperQueryList([]string{fmt.Sprintf("DROP TABLE `%s`.`%s`", dbName, shadow)})
+		return "", fmt.Errorf("failed to install copy triggers on %v: %v", table, err)
+	}
+
+	migration := &onlineSchemaChangeMigration{
+		id:          newOnlineSchemaChangeID(),
+		dbName:      dbName,
+		table:       table,
+		shadowTable: shadow,
+		done:        make(chan struct{}),
+	}
+	mysqld.mu.Lock()
+	mysqld.onlineSchemaChanges[migration.id] = migration
+	mysqld.mu.Unlock()
+
+	go mysqld.copyOnlineSchemaChangeRows(migration, pkColumns, sharedCols, colTypes)
+
+	return migration.id, nil
+}
+
+// OnlineSchemaChangeStatus is part of the MysqlDaemon interface
+func (mysqld *Mysqld) OnlineSchemaChangeStatus(migrationID string) (*OnlineSchemaChangeStatus, error) {
+	migration, err := mysqld.onlineSchemaChangeByID(migrationID)
+	if err != nil {
+		return nil, err
+	}
+	if failure := migration.lastError(); failure != nil {
+		return nil, fmt.Errorf("online schema change migration %v failed: %v", migrationID, failure)
+	}
+	status := migration.snapshot()
+	return &status, nil
+}
+
+// CutoverOnlineSchemaChange is part of the MysqlDaemon interface
+func (mysqld *Mysqld) CutoverOnlineSchemaChange(migrationID string) error {
+	migration, err := mysqld.onlineSchemaChangeByID(migrationID)
+	if err != nil {
+		return err
+	}
+	if !migration.isDone() {
+		return fmt.Errorf("online schema change migration %v has not finished copying rows yet", migrationID)
+	}
+
+	// Rename first, so the triggers keep mirroring writes into the shadow
+	// table right up to the swap; they then sit on the renamed original
+	// and are dropped before it.
+	cmds := []string{
+		fmt.Sprintf("RENAME TABLE `%s`.`%s` TO `%s`.`%s`, `%s`.`%s` TO `%s`.`%s`",
+			migration.dbName, migration.table, migration.dbName, oldTableName(migration.table),
+			migration.dbName, migration.shadowTable, migration.dbName, migration.table),
+	}
+	cmds = append(cmds, dropTriggerCommands(migration.dbName, migration.table)...)
+	cmds = append(cmds,
+		fmt.Sprintf("DROP TABLE `%s`.`%s`", migration.dbName, oldTableName(migration.table)),
+		fmt.Sprintf("DROP TABLE IF EXISTS `%s`.`%s`", migration.dbName, progressTableName(migration.table)),
+	)
+	if err := mysqld.ExecuteSuperQueryList(cmds); err != nil {
+		return fmt.Errorf("cutover failed for migration %v: %v", migrationID, err)
+	}
+
+	mysqld.mu.Lock()
+	delete(mysqld.onlineSchemaChanges, migrationID)
+	mysqld.mu.Unlock()
+	return nil
+}
+
+// AbortOnlineSchemaChange is part of the MysqlDaemon interface
+func (mysqld *Mysqld) AbortOnlineSchemaChange(migrationID string) error {
+	migration, err := mysqld.onlineSchemaChangeByID(migrationID)
+	if err != nil {
+		return err
+	}
+	migration.abort()
+	<-migration.done
+
+	cmds := dropTriggerCommands(migration.dbName, migration.table)
+	cmds = append(cmds,
+		fmt.Sprintf("DROP TABLE IF EXISTS `%s`.`%s`", migration.dbName, migration.shadowTable),
+		fmt.Sprintf("DROP TABLE IF EXISTS `%s`.`%s`", migration.dbName, progressTableName(migration.table)),
+	)
+	if err := mysqld.ExecuteSuperQueryList(cmds); err != nil {
+		return fmt.Errorf("cleanup failed while aborting migration %v: %v", migrationID, err)
+	}
+
+	mysqld.mu.Lock()
+	delete(mysqld.onlineSchemaChanges, migrationID)
+	mysqld.mu.Unlock()
+	return nil
+}
+
+func (mysqld *Mysqld) onlineSchemaChangeByID(migrationID string) (*onlineSchemaChangeMigration, error) {
+	mysqld.mu.Lock()
+	migration, ok := mysqld.onlineSchemaChanges[migrationID]
+	mysqld.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown online schema change migration: %v", migrationID)
+	}
+	return migration, nil
+}
+
+// primaryKeyColumns returns the ordered list of column names making up
+// table's primary key. Chunk copying and the copy triggers both rely on
+// a primary key to identify rows, the same way normal row-based
+// replication does.
+func (mysqld *Mysqld) primaryKeyColumns(dbName, table string) ([]string, error) {
+	qr, err := mysqld.FetchSuperQuery(fmt.Sprintf("SHOW KEYS FROM `%s`.`%s` WHERE Key_name = 'PRIMARY'", dbName, table))
+	if err != nil {
+		return nil, err
+	}
+	columnIndex := -1
+	for i, field := range qr.Fields {
+		if field.Name == "Column_name" {
+			columnIndex = i
+			break
+		}
+	}
+	if columnIndex == -1 {
+		return nil, fmt.Errorf("SHOW KEYS FROM %v.%v did not return a Column_name field", dbName, table)
+	}
+	columns := make([]string, 0, len(qr.Rows))
+	for _, row := range qr.Rows {
+		columns = append(columns, row[columnIndex].String())
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %v.%v has no primary key; online schema change requires one", dbName, table)
+	}
+	return columns, nil
+}
+
+// tableColumns returns table's column names in declaration order, along
+// with a map from column name to its lowercased DATA_TYPE. Both the copy
+// triggers/loop (to cope with an ALTER that adds or drops columns) and
+// PK range-predicate quoting rely on this.
+func (mysqld *Mysqld) tableColumns(dbName, table string) ([]string, map[string]string, error) {
+	qr, err := mysqld.FetchSuperQuery(fmt.Sprintf(
+		"SELECT COLUMN_NAME, DATA_TYPE FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = '%s' AND TABLE_NAME = '%s' ORDER BY ORDINAL_POSITION",
+		dbName, table))
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := make([]string, 0, len(qr.Rows))
+	types := make(map[string]string, len(qr.Rows))
+	for _, row := range qr.Rows {
+		name := row[0].String()
+		columns = append(columns, name)
+		types[name] = strings.ToLower(row[1].String())
+	}
+	return columns, types, nil
+}
+
+// sharedColumns returns the columns present in both orig and shadow, in
+// orig's order. An ALTER that adds or drops columns leaves the shadow
+// table's column set different from the original's, and SELECT *-ing
+// across that mismatch fails with "Column count doesn't match value
+// count"; copying only the shared columns avoids that.
+func sharedColumns(orig, shadow []string) []string {
+	inShadow := make(map[string]bool, len(shadow))
+	for _, col := range shadow {
+		inShadow[col] = true
+	}
+	shared := make([]string, 0, len(orig))
+	for _, col := range orig {
+		if inShadow[col] {
+			shared = append(shared, col)
+		}
+	}
+	return shared
+}
+
+// installTriggerCommands returns the AFTER INSERT/UPDATE/DELETE triggers
+// that mirror writes on table into shadow while the copy is in flight.
+// columns is the set of shared columns to copy (see sharedColumns).
+func installTriggerCommands(dbName, table, shadow string, pkColumns, columns []string) []string {
+	pkList := strings.Join(pkColumns, ", ")
+	colList := "`" + strings.Join(columns, "`, `") + "`"
+	pkJoin := make([]string, len(pkColumns))
+	for i, col := range pkColumns {
+		pkJoin[i] = fmt.Sprintf("`%s` = OLD.`%s`", col, col)
+	}
+	newPKTuple := "NEW.`" + strings.Join(pkColumns, "`, NEW.`") + "`"
+	return []string{
+		fmt.Sprintf(
+			"CREATE TRIGGER `%s` AFTER INSERT ON `%s`.`%s` FOR EACH ROW REPLACE INTO `%s`.`%s` (%s) SELECT %s FROM `%s`.`%s` WHERE (%s) = (%s)",
+			triggerName(table, "ins"), dbName, table, dbName, shadow, colList, colList, dbName, table, pkList, newPKTuple),
+		fmt.Sprintf(
+			"CREATE TRIGGER `%s` AFTER UPDATE ON `%s`.`%s` FOR EACH ROW REPLACE INTO `%s`.`%s` (%s) SELECT %s FROM `%s`.`%s` WHERE (%s) = (%s)",
+			triggerName(table, "upd"), dbName, table, dbName, shadow, colList, colList, dbName, table, pkList, newPKTuple),
+		fmt.Sprintf(
+			"CREATE TRIGGER `%s` AFTER DELETE ON `%s`.`%s` FOR EACH ROW DELETE FROM `%s`.`%s` WHERE %s",
+			triggerName(table, "del"), dbName, table, dbName, shadow, strings.Join(pkJoin, " AND ")),
+	}
+}
+
+// dropTriggerCommands drops the three copy triggers installed by
+// installTriggerCommands. It is safe to run even if a trigger was never
+// created.
+func dropTriggerCommands(dbName, table string) []string {
+	return []string{
+		fmt.Sprintf("DROP TRIGGER IF EXISTS `%s`.`%s`", dbName, triggerName(table, "ins")),
+		fmt.Sprintf("DROP TRIGGER IF EXISTS `%s`.`%s`", dbName, triggerName(table, "upd")),
+		fmt.Sprintf("DROP TRIGGER IF EXISTS `%s`.`%s`", dbName, triggerName(table, "del")),
+	}
+}
+
+// copyOnlineSchemaChangeRows runs in its own goroutine for the lifetime
+// of a migration. It walks the original table in PK order, copying rows
+// into the shadow table one bounded chunk at a time, pausing when a
+// slave falls too far behind, and persisting its progress both in
+// migration (so OnlineSchemaChangeStatus can report it) and in a sidecar
+// progress table (so it survives a Mysqld restart even though migration
+// itself does not). columns is the set of shared columns to copy (see
+// sharedColumns); pkColTypes maps each of pkColumns to its DATA_TYPE, so
+// chunk boundaries can be quoted correctly for non-numeric primary keys.
+func (mysqld *Mysqld) copyOnlineSchemaChangeRows(migration *onlineSchemaChangeMigration, pkColumns, columns []string, pkColTypes map[string]string) {
+	pkList := strings.Join(pkColumns, ", ")
+	colList := "`" + strings.Join(columns, "`, `") + "`"
+
+	if countFields, err := mysqld.fetchSuperQueryMap(
+		fmt.Sprintf("SELECT COUNT(*) AS cnt FROM `%s`.`%s`", migration.dbName, migration.table)); err == nil {
+		if total, err := strconv.ParseInt(countFields["cnt"], 10, 64); err == nil {
+			migration.setTotal(total)
+		}
+	}
+
+	// sqlLastPK is the quoted/escaped tuple usable in a WHERE clause;
+	// displayLastPK is the same PK rendered as plain, unquoted values for
+	// OnlineSchemaChangeStatus and the progress table.
+	var sqlLastPK, displayLastPK string
+
+	for {
+		if migration.isAborted() {
+			migration.finishAborted()
+			return
+		}
+
+		if lag, err := mysqld.secondsBehindMaster(); err == nil && lag > onlineSchemaChangeLagThreshold {
+			migration.setThrottled(true)
+			time.Sleep(onlineSchemaChangeLagCheckInterval)
+			continue
+		}
+		migration.setThrottled(false)
+
+		where := "1 = 1"
+		if sqlLastPK != "" {
+			where = fmt.Sprintf("(%s) > (%s)", pkList, sqlLastPK)
+		}
+		boundaryRows, err := mysqld.FetchSuperQuery(fmt.Sprintf(
+			"SELECT %s FROM `%s`.`%s` WHERE %s ORDER BY %s LIMIT %d",
+			pkList, migration.dbName, migration.table, where, pkList, onlineSchemaChangeChunkSize))
+		if err != nil {
+			migration.fail(fmt.Errorf("failed to read next chunk boundary: %v", err))
+			return
+		}
+		if len(boundaryRows.Rows) == 0 {
+			migration.finish()
+			return
+		}
+
+		lastRow := boundaryRows.Rows[len(boundaryRows.Rows)-1]
+		sqlValues := make([]string, len(lastRow))
+		displayValues := make([]string, len(lastRow))
+		for i, v := range lastRow {
+			raw := v.String()
+			sqlValues[i] = quoteColumnValue(pkColTypes[pkColumns[i]], raw)
+			displayValues[i] = raw
+		}
+		sqlLastPK = strings.Join(sqlValues, ", ")
+		displayLastPK = strings.Join(displayValues, ", ")
+
+		copySQL := fmt.Sprintf(
+			"REPLACE INTO `%s`.`%s` (%s) SELECT %s FROM `%s`.`%s` WHERE %s AND (%s) <= (%s) ORDER BY %s",
+			migration.dbName, migration.shadowTable, colList, colList, migration.dbName, migration.table, where, pkList, sqlLastPK, pkList)
+		progressSQL := fmt.Sprintf(
+			"REPLACE INTO `%s`.`%s` (migration_id, last_pk, rows_copied) VALUES ('%s', '%s', %d)",
+			migration.dbName, progressTableName(migration.table), migration.id,
+			strings.Replace(displayLastPK, "'", "''", -1), migration.snapshot().RowsCopied+int64(len(boundaryRows.Rows)))
+
+		var copyErr error
+		for attempt := 0; attempt <= onlineSchemaChangeLockWaitRetries; attempt++ {
+			copyErr = mysqld.ExecuteSuperQueryList([]string{copySQL, progressSQL})
+			if copyErr == nil || !isLockWaitTimeout(copyErr) {
+				break
+			}
+			time.Sleep(onlineSchemaChangeCopyInterval)
+		}
+		if copyErr != nil {
+			migration.fail(fmt.Errorf("failed to copy chunk up to PK (%v): %v", displayLastPK, copyErr))
+			return
+		}
+
+		migration.recordChunk(displayLastPK, int64(len(boundaryRows.Rows)))
+		onlineSchemaChangeRowsCopied.Add(migration.id, int64(len(boundaryRows.Rows)))
+
+		if len(boundaryRows.Rows) < onlineSchemaChangeChunkSize {
+			migration.finish()
+			return
+		}
+
+		time.Sleep(onlineSchemaChangeCopyInterval)
+	}
+}
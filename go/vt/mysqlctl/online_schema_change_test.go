@@ -0,0 +1,77 @@
+// Copyright 2012, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFakeOnlineSchemaChangeDrivesThroughChunkBoundaries(t *testing.T) {
+	fmd := NewFakeMysqlDaemon()
+	const migrationID = "osc_test"
+	fmd.OnlineSchemaChangeChunkBoundaries = map[string][]string{
+		migrationID: {"100", "200", "300"},
+	}
+
+	// Cutover before any chunk has been copied should fail: the
+	// migration isn't known to be Done yet.
+	if err := fmd.CutoverOnlineSchemaChange(migrationID); err == nil {
+		t.Fatalf("CutoverOnlineSchemaChange should fail before any chunk has been copied")
+	}
+
+	for i, want := range []string{"100", "200", "300"} {
+		if err := fmd.AdvanceOnlineSchemaChangeChunk(migrationID); err != nil {
+			t.Fatalf("AdvanceOnlineSchemaChangeChunk(%v) failed: %v", i, err)
+		}
+		status, err := fmd.OnlineSchemaChangeStatus(migrationID)
+		if err != nil {
+			t.Fatalf("OnlineSchemaChangeStatus failed: %v", err)
+		}
+		if status.LastPK != want {
+			t.Errorf("after chunk %v: LastPK = %v, want %v", i, status.LastPK, want)
+		}
+		if status.RowsCopied != int64(i+1) {
+			t.Errorf("after chunk %v: RowsCopied = %v, want %v", i, status.RowsCopied, i+1)
+		}
+		wantDone := i == 2
+		if status.Done != wantDone {
+			t.Errorf("after chunk %v: Done = %v, want %v", i, status.Done, wantDone)
+		}
+	}
+
+	if got, want := fmd.OnlineSchemaChangeCopyEvents[migrationID], []string{"100", "200", "300"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("OnlineSchemaChangeCopyEvents = %v, want %v", got, want)
+	}
+
+	if err := fmd.AdvanceOnlineSchemaChangeChunk(migrationID); err == nil {
+		t.Errorf("AdvanceOnlineSchemaChangeChunk should fail once all boundaries are consumed")
+	}
+
+	if err := fmd.CutoverOnlineSchemaChange(migrationID); err != nil {
+		t.Errorf("CutoverOnlineSchemaChange failed once migration was done: %v", err)
+	}
+	if got, want := fmd.OnlineSchemaChangeCutovers, []string{migrationID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("OnlineSchemaChangeCutovers = %v, want %v", got, want)
+	}
+}
+
+func TestFakeOnlineSchemaChangeAbort(t *testing.T) {
+	fmd := NewFakeMysqlDaemon()
+	const migrationID = "osc_abort_test"
+	fmd.OnlineSchemaChangeChunkBoundaries = map[string][]string{
+		migrationID: {"10"},
+	}
+
+	if err := fmd.AdvanceOnlineSchemaChangeChunk(migrationID); err != nil {
+		t.Fatalf("AdvanceOnlineSchemaChangeChunk failed: %v", err)
+	}
+	if err := fmd.AbortOnlineSchemaChange(migrationID); err != nil {
+		t.Fatalf("AbortOnlineSchemaChange failed: %v", err)
+	}
+	if got, want := fmd.OnlineSchemaChangeAborts, []string{migrationID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("OnlineSchemaChangeAborts = %v, want %v", got, want)
+	}
+}
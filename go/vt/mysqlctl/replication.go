@@ -0,0 +1,239 @@
+// Copyright 2012, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"golang.org/x/net/context"
+)
+
+// masterConnectRetry is the MASTER_CONNECT_RETRY value used whenever we
+// point a slave at a new master.
+const masterConnectRetry = 10 * time.Second
+
+// semiSyncMasterTimeout is the rpl_semi_sync_master_timeout applied
+// whenever master-side semi-sync is enabled, so a stalled or
+// disconnected semi-sync slave can't block commits on the master
+// forever.
+const semiSyncMasterTimeout = 10 * time.Second
+
+// DurabilityPolicy decides how MySQL semi-sync replication should be
+// configured for a tablet as it takes on a new role during a reparent.
+// The reparent workflow (not part of this package) owns the decision of
+// *which* tablets are durable; SetDurabilityPolicy just gives Mysqld a
+// way to apply that decision locally via PromoteSlave / SetMasterCommands.
+type DurabilityPolicy interface {
+	// MasterSemiSync returns whether this tablet's master side of
+	// semi-sync should be enabled once it becomes the master.
+	MasterSemiSync() bool
+
+	// SlaveSemiSync returns whether this tablet's slave side of
+	// semi-sync should be enabled, given the host name of the master it
+	// is about to replicate from (as passed to SetMasterCommands).
+	SlaveSemiSync(masterHost string) bool
+}
+
+// SetDurabilityPolicy configures the policy PromoteSlave and
+// SetMasterCommands consult when deciding how to (re)configure semi-sync
+// on this tablet. Passing nil (the default) leaves semi-sync untouched
+// during reparents.
+func (mysqld *Mysqld) SetDurabilityPolicy(policy DurabilityPolicy) {
+	mysqld.durability = policy
+}
+
+// SlaveStatus is part of the MysqlDaemon interface
+func (mysqld *Mysqld) SlaveStatus() (proto.ReplicationStatus, error) {
+	fields, err := mysqld.fetchSuperQueryMap("SHOW SLAVE STATUS")
+	if err != nil {
+		return proto.ReplicationStatus{}, err
+	}
+	pos, err := proto.DecodeReplicationPosition(fields["Executed_Gtid_Set"])
+	if err != nil {
+		return proto.ReplicationStatus{}, fmt.Errorf("can't decode slave position: %v", err)
+	}
+	masterPort, _ := strconv.Atoi(fields["Master_Port"])
+	return proto.ReplicationStatus{
+		Position:        pos,
+		SlaveIORunning:  fields["Slave_IO_Running"] == "Yes",
+		SlaveSQLRunning: fields["Slave_SQL_Running"] == "Yes",
+		MasterHost:      fields["Master_Host"],
+		MasterPort:      masterPort,
+	}, nil
+}
+
+// secondsBehindMaster returns the current value of Seconds_Behind_Master
+// from SHOW SLAVE STATUS, used by the online schema change copy loop to
+// throttle itself against replication lag.
+func (mysqld *Mysqld) secondsBehindMaster() (int, error) {
+	fields, err := mysqld.fetchSuperQueryMap("SHOW SLAVE STATUS")
+	if err != nil {
+		return 0, err
+	}
+	value := fields["Seconds_Behind_Master"]
+	if value == "" || value == "NULL" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// ResetReplicationCommands is part of the MysqlDaemon interface
+func (mysqld *Mysqld) ResetReplicationCommands() ([]string, error) {
+	return []string{
+		"STOP SLAVE",
+		"RESET SLAVE ALL",
+		"RESET MASTER",
+	}, nil
+}
+
+// MasterPosition is part of the MysqlDaemon interface
+func (mysqld *Mysqld) MasterPosition() (proto.ReplicationPosition, error) {
+	fields, err := mysqld.fetchSuperQueryMap("SHOW MASTER STATUS")
+	if err != nil {
+		return proto.ReplicationPosition{}, err
+	}
+	return proto.DecodeReplicationPosition(fields["Executed_Gtid_Set"])
+}
+
+// IsReadOnly is part of the MysqlDaemon interface
+func (mysqld *Mysqld) IsReadOnly() (bool, error) {
+	fields, err := mysqld.fetchSuperQueryMap("SHOW VARIABLES LIKE 'read_only'")
+	if err != nil {
+		return false, err
+	}
+	return fields["Value"] == "ON", nil
+}
+
+// SetReadOnly is part of the MysqlDaemon interface
+func (mysqld *Mysqld) SetReadOnly(on bool) error {
+	return mysqld.ExecuteSuperQueryList([]string{
+		fmt.Sprintf("SET GLOBAL read_only = %v", boolToOnOff(on)),
+	})
+}
+
+// StartReplicationCommands is part of the MysqlDaemon interface
+func (mysqld *Mysqld) StartReplicationCommands(status *proto.ReplicationStatus) ([]string, error) {
+	return []string{
+		"STOP SLAVE",
+		fmt.Sprintf("CHANGE MASTER TO MASTER_HOST='%s', MASTER_PORT=%d, MASTER_CONNECT_RETRY=%d",
+			status.MasterHost, status.MasterPort, int(masterConnectRetry.Seconds())),
+		"START SLAVE",
+	}, nil
+}
+
+// SetMasterCommands is part of the MysqlDaemon interface
+func (mysqld *Mysqld) SetMasterCommands(masterHost string, masterPort int) ([]string, error) {
+	if mysqld.durability != nil {
+		// This tablet is becoming a slave of masterHost: the master
+		// side of semi-sync must be off, and the slave side follows
+		// whatever the durability policy wants for this master.
+		if err := mysqld.SetSemiSyncEnabled(false, mysqld.durability.SlaveSemiSync(masterHost)); err != nil {
+			return nil, fmt.Errorf("failed to configure semi-sync for new slave of %v: %v", masterHost, err)
+		}
+	}
+	return []string{
+		"STOP SLAVE",
+		fmt.Sprintf("CHANGE MASTER TO MASTER_HOST='%s', MASTER_PORT=%d", masterHost, masterPort),
+		"START SLAVE",
+	}, nil
+}
+
+// WaitForReparentJournal is part of the MysqlDaemon interface
+func (mysqld *Mysqld) WaitForReparentJournal(ctx context.Context, timeCreatedNS int64) error {
+	return fmt.Errorf("WaitForReparentJournal is not implemented for this build of mysqlctl")
+}
+
+// DemoteMaster is part of the MysqlDaemon interface
+func (mysqld *Mysqld) DemoteMaster() (proto.ReplicationPosition, error) {
+	// Taking and releasing the global read lock on one connection waits
+	// for the transactions currently in flight to finish.
+	if err := mysqld.ExecuteSuperQueryList([]string{
+		"FLUSH TABLES WITH READ LOCK",
+		"UNLOCK TABLES",
+	}); err != nil {
+		return proto.ReplicationPosition{}, err
+	}
+	return mysqld.MasterPosition()
+}
+
+// WaitMasterPos is part of the MysqlDaemon interface
+func (mysqld *Mysqld) WaitMasterPos(pos proto.ReplicationPosition, waitTimeout time.Duration) error {
+	return fmt.Errorf("WaitMasterPos is not implemented for this build of mysqlctl")
+}
+
+// PromoteSlave is part of the MysqlDaemon interface
+func (mysqld *Mysqld) PromoteSlave(hookExtraEnv map[string]string) (proto.ReplicationPosition, error) {
+	if err := mysqld.ExecuteSuperQueryList([]string{"STOP SLAVE", "RESET SLAVE ALL"}); err != nil {
+		return proto.ReplicationPosition{}, err
+	}
+	if mysqld.durability != nil {
+		// This tablet is becoming the master: turn on the master side
+		// of semi-sync if the durability policy requires it, so we
+		// don't lose acknowledged transactions on the next failover.
+		if err := mysqld.SetSemiSyncEnabled(mysqld.durability.MasterSemiSync(), false); err != nil {
+			return proto.ReplicationPosition{}, fmt.Errorf("failed to configure semi-sync for new master: %v", err)
+		}
+	}
+	return mysqld.MasterPosition()
+}
+
+// SemiSyncEnabled is part of the MysqlDaemon interface
+func (mysqld *Mysqld) SemiSyncEnabled() (master, slave bool, err error) {
+	masterFields, err := mysqld.fetchSuperQueryMap("SHOW VARIABLES LIKE 'rpl_semi_sync_master_enabled'")
+	if err != nil {
+		return false, false, err
+	}
+	slaveFields, err := mysqld.fetchSuperQueryMap("SHOW VARIABLES LIKE 'rpl_semi_sync_slave_enabled'")
+	if err != nil {
+		return false, false, err
+	}
+	return masterFields["Value"] == "ON", slaveFields["Value"] == "ON", nil
+}
+
+// SetSemiSyncEnabled is part of the MysqlDaemon interface
+func (mysqld *Mysqld) SetSemiSyncEnabled(master, slave bool) error {
+	cmds := []string{
+		fmt.Sprintf("SET GLOBAL rpl_semi_sync_master_enabled = %v", boolToOnOff(master)),
+		fmt.Sprintf("SET GLOBAL rpl_semi_sync_slave_enabled = %v", boolToOnOff(slave)),
+	}
+	if master {
+		cmds = append(cmds, fmt.Sprintf("SET GLOBAL rpl_semi_sync_master_timeout = %d", semiSyncMasterTimeout/time.Millisecond))
+	}
+	return mysqld.ExecuteSuperQueryList(cmds)
+}
+
+// SemiSyncStatus is part of the MysqlDaemon interface
+func (mysqld *Mysqld) SemiSyncStatus() (clientsCount int, netAvg time.Duration, err error) {
+	clientsFields, err := mysqld.fetchSuperQueryMap("SHOW STATUS LIKE 'Rpl_semi_sync_master_clients'")
+	if err != nil {
+		return 0, 0, err
+	}
+	clientsCount, err = strconv.Atoi(clientsFields["Value"])
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't parse Rpl_semi_sync_master_clients: %v", err)
+	}
+
+	waitFields, err := mysqld.fetchSuperQueryMap("SHOW STATUS LIKE 'Rpl_semi_sync_master_net_avg_wait_time'")
+	if err != nil {
+		return 0, 0, err
+	}
+	waitMicros, err := strconv.Atoi(waitFields["Value"])
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't parse Rpl_semi_sync_master_net_avg_wait_time: %v", err)
+	}
+	return clientsCount, time.Duration(waitMicros) * time.Microsecond, nil
+}
+
+// boolToOnOff renders a bool as the ON/OFF literal MySQL expects for
+// boolean-ish global variables.
+func boolToOnOff(b bool) string {
+	if b {
+		return "ON"
+	}
+	return "OFF"
+}
@@ -0,0 +1,26 @@
+// Copyright 2012, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"fmt"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/proto"
+)
+
+// GetSchema is part of the MysqlDaemon interface
+func (mysqld *Mysqld) GetSchema(dbName string, tables, excludeTables []string, includeViews bool) (*proto.SchemaDefinition, error) {
+	return nil, fmt.Errorf("GetSchema is not implemented for this build of mysqlctl")
+}
+
+// PreflightSchemaChange is part of the MysqlDaemon interface
+func (mysqld *Mysqld) PreflightSchemaChange(dbName string, change string) (*proto.SchemaChangeResult, error) {
+	return nil, fmt.Errorf("PreflightSchemaChange is not implemented for this build of mysqlctl")
+}
+
+// ApplySchemaChange is part of the MysqlDaemon interface
+func (mysqld *Mysqld) ApplySchemaChange(dbName string, change *proto.SchemaChange) (*proto.SchemaChangeResult, error) {
+	return nil, fmt.Errorf("ApplySchemaChange is not implemented for this build of mysqlctl")
+}